@@ -0,0 +1,191 @@
+package oplog
+
+import (
+	"context"
+	"time"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis/v8"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChangeStreamTailer persistently tails a cluster-wide MongoDB change
+// stream, handling reconnection and resumption of where it left off.
+//
+// It's an alternative to Tailer for deployments where reading
+// local.oplog.rs directly isn't viable -- most notably sharded clusters,
+// where each shard has its own independent oplog and there's no single
+// cluster-wide position to resume from. ChangeStreamTailer uses the
+// official go.mongodb.org/mongo-driver client instead of globalsign/mgo,
+// and resumes via an opaque resume token (see redispub.LastProcessedToken)
+// rather than a bson.MongoTimestamp.
+type ChangeStreamTailer struct {
+	MongoClient *mongo.Client
+	RedisClient redis.UniversalClient
+	RedisPrefix string
+	MaxCatchUp  time.Duration
+
+	// Transformers is a chain applied to each entry parsed from a change
+	// event, before it's processed and published -- the same chain
+	// Tailer.Transformers applies, so a NamespaceFilter or
+	// ScriptTransformer configured by an operator behaves identically
+	// regardless of which Source they've selected. See transform.go.
+	Transformers []Transformer
+}
+
+// rawChangeEvent is the subset of a MongoDB change event document that we
+// need to translate it into an oplogEntry.
+type rawChangeEvent struct {
+	OperationType string `bson:"operationType"`
+	Namespace     struct {
+		DB   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey struct {
+		ID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument driverbson.Raw      `bson:"fullDocument"`
+	ClusterTime  primitive.Timestamp `bson:"clusterTime"`
+}
+
+// Tail begins tailing the change stream. It doesn't return unless ctx is
+// canceled, in which case it wraps up its work and then returns. It has
+// the same retry-on-error behavior as Tailer.Tail.
+func (tailer *ChangeStreamTailer) Tail(ctx context.Context, out chan<- *redispub.Publication) {
+	for {
+		log.Log.Info("Starting change stream tailing")
+		tailer.tailOnce(ctx, out)
+		log.Log.Info("Change stream tailing ended")
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Log.Errorw("Change stream tailing stopped prematurely. Waiting a second and then retrying.")
+		time.Sleep(requeryDuration)
+	}
+}
+
+func (tailer *ChangeStreamTailer) tailOnce(ctx context.Context, out chan<- *redispub.Publication) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	resumeToken, tokenTime, tokenErr := redispub.LastProcessedToken(ctx, tailer.RedisClient, tailer.RedisPrefix)
+	if tokenErr == nil && tokenTime.After(time.Now().Add(-1*tailer.MaxCatchUp)) {
+		log.Log.Infof("Found last processed resume token, resuming change stream from it")
+		opts.SetResumeAfter(driverbson.Raw(resumeToken))
+	} else if tokenErr != nil && tokenErr != redis.Nil {
+		log.Log.Errorw("Error querying Redis for last processed resume token. Will start from current cluster time.",
+			"error", tokenErr)
+	}
+
+	stream, err := tailer.MongoClient.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		log.Log.Errorw("Error opening change stream", "error", err)
+		return
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		pubs := tailer.unmarshalEntry(stream.Current)
+
+		if setErr := redispub.SetLastProcessedToken(ctx, tailer.RedisClient, tailer.RedisPrefix, []byte(stream.ResumeToken())); setErr != nil {
+			log.Log.Errorw("Error persisting change stream resume token", "error", setErr)
+		}
+
+		for _, pub := range pubs {
+			out <- pub
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		log.Log.Errorw("Error from change stream", "error", err)
+	}
+}
+
+// unmarshalEntry translates a single change stream event into the same
+// oplogEntry/Publication values that Tailer.unmarshalEntry produces from a
+// raw oplog.rs document -- including running it through tailer.Transformers
+// -- so the rest of the pipeline (processOplogEntry, metrics, redispub)
+// doesn't need to know which source produced them.
+func (tailer *ChangeStreamTailer) unmarshalEntry(rawEvent driverbson.Raw) (pubs []*redispub.Publication) {
+	var event rawChangeEvent
+	if err := driverbson.Unmarshal(rawEvent, &event); err != nil {
+		log.Log.Errorw("Error unmarshaling change stream event", "error", err)
+		return nil
+	}
+
+	operation, ok := changeEventOperations[event.OperationType]
+	if !ok {
+		// e.g. "drop", "rename", "invalidate" -- not operations we publish
+		return nil
+	}
+
+	var data map[string]interface{}
+	if len(event.FullDocument) > 0 {
+		if err := driverbson.Unmarshal(event.FullDocument, &data); err != nil {
+			log.Log.Errorf("unmarshalling change stream fullDocument: %v", err)
+			return nil
+		}
+	}
+
+	entry := oplogEntry{
+		Operation: operation,
+		Timestamp: bson.MongoTimestamp(uint64(event.ClusterTime.T)<<32 | uint64(event.ClusterTime.I)),
+		Namespace: event.Namespace.DB + "." + event.Namespace.Coll,
+		Data:      data,
+		DocID:     event.DocumentKey.ID,
+	}
+	entry.Database, entry.Collection = parseNamespace(entry.Namespace)
+
+	status := "ignored"
+	database := entry.Database
+	defer func() {
+		metricOplogEntriesReceived.WithLabelValues(database, status, "").Observe(float64(len(rawEvent)))
+	}()
+
+	entries, transformErr := runTransformers(tailer.Transformers, []oplogEntry{entry})
+	if transformErr != nil {
+		status = "error"
+		log.Log.Errorw("Error transforming change stream event", "error", transformErr)
+		return nil
+	}
+
+	if len(entries) == 0 {
+		status = "filtered"
+		return nil
+	}
+
+	for _, entry := range entries {
+		pub, err := processOplogEntry(&entry)
+		if err != nil {
+			status = "error"
+			log.Log.Errorw("Error processing change stream event",
+				"op", entry,
+				"error", err,
+				"database", entry.Database,
+				"collection", entry.Collection)
+			continue
+		}
+
+		status = "processed"
+		pubs = append(pubs, pub)
+	}
+
+	return pubs
+}
+
+// changeEventOperations maps change stream operationType values to the
+// single-letter operation codes used throughout package oplog (the same
+// codes the raw oplog uses: operationInsert, operationUpdate, etc).
+var changeEventOperations = map[string]string{
+	"insert":  operationInsert,
+	"update":  operationUpdate,
+	"replace": operationUpdate,
+	"delete":  operationRemove,
+}
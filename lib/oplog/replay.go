@@ -0,0 +1,67 @@
+package oplog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Replay tails the oplog from just after "from" up to and including "to",
+// then closes out and returns. Unlike Tail, it never consults Redis for a
+// resume timestamp (so a stale or missing last-processed-timestamp entry
+// can't affect the range replayed), and it doesn't retry or keep tailing
+// once it reads an entry past "to".
+//
+// Replay only detects the end of the range by reading an oplog entry whose
+// timestamp is after "to". Until such an entry exists, it keeps tailing
+// (and does not close out) until ctx is canceled.
+//
+// This lets operators rebuild downstream caches after a Redis outage (or
+// populate a new consumer) by replaying a known range of the oplog, without
+// restarting normal streaming.
+func (tailer *Tailer) Replay(ctx context.Context, out chan<- *redispub.Publication, from, to bson.MongoTimestamp) {
+	// Logged as the seconds-only component of each bson.MongoTimestamp, not
+	// the full 64-bit value, so a "to" printed here can be fed straight
+	// back into ParseReplayTimestamp as the next run's "--from" (its
+	// numeric branch expects a bare seconds count, not an already-shifted
+	// 64-bit timestamp).
+	log.Log.Infow("Starting oplog replay", "from", int64(from>>32), "to", int64(to>>32))
+
+	// pastTo is applied both to skip delivering any entry after "to" (so
+	// "to" itself is still published, per the inclusive range documented
+	// above) and, once the whole raw oplog document has been handled, to
+	// stop tailing -- see tailOnceFrom and unmarshalEntry.
+	pastTo := func(ts bson.MongoTimestamp) bool {
+		return ts > to
+	}
+
+	session := tailer.MongoClient.Copy()
+	oplogCollection := session.DB("local").C("oplog.rs")
+
+	tailer.tailOnceFrom(ctx, oplogCollection, out, from, pastTo)
+
+	log.Log.Info("Oplog replay finished")
+	close(out)
+}
+
+// ParseReplayTimestamp parses a --from/--to value for Replay. It accepts
+// either an RFC3339 timestamp ("2020-01-02T15:04:05Z") or the raw seconds
+// component of a bson.MongoTimestamp (e.g. "1577977445"); the latter is
+// useful when resuming from a timestamp logged by a previous run.
+func ParseReplayTimestamp(value string) (bson.MongoTimestamp, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return bson.MongoTimestamp(t.Unix() << 32), nil
+	}
+
+	var seconds int64
+	if _, err := fmt.Sscanf(value, "%d", &seconds); err != nil {
+		return 0, fmt.Errorf("could not parse %q as an RFC3339 timestamp or a Unix timestamp: %w", value, err)
+	}
+
+	return bson.MongoTimestamp(seconds << 32), nil
+}
@@ -0,0 +1,101 @@
+package oplog
+
+import (
+	"testing"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// rawTransactionDoc builds the raw bytes of a single applyOps transaction
+// document at ts, containing one insert per id in ids.
+func rawTransactionDoc(t *testing.T, ts bson.MongoTimestamp, ids ...int) bson.Raw {
+	t.Helper()
+
+	var ops []rawOplogEntry
+	for _, id := range ids {
+		docBytes, err := bson.Marshal(bson.M{"_id": id})
+		if err != nil {
+			t.Fatalf("marshaling op doc: %v", err)
+		}
+
+		ops = append(ops, rawOplogEntry{
+			Operation: operationInsert,
+			Namespace: "testdb.testcoll",
+			Doc:       bson.Raw{Kind: 0x03, Data: docBytes},
+		})
+	}
+
+	txBytes, err := bson.Marshal(struct {
+		ApplyOps []rawOplogEntry `bson:"applyOps"`
+	}{ApplyOps: ops})
+	if err != nil {
+		t.Fatalf("marshaling applyOps: %v", err)
+	}
+
+	topBytes, err := bson.Marshal(rawOplogEntry{
+		Timestamp: ts,
+		Operation: operationCommand,
+		Namespace: "admin.$cmd",
+		Doc:       bson.Raw{Kind: 0x03, Data: txBytes},
+	})
+	if err != nil {
+		t.Fatalf("marshaling raw oplog doc: %v", err)
+	}
+
+	return bson.Raw{Kind: 0x03, Data: topBytes}
+}
+
+// TestUnmarshalEntryStopPredicateAppliesPerEntry covers the Replay boundary
+// bug fixed alongside this test: a multi-op transaction landing exactly on
+// "to" must still have all of its ops published (the documented inclusive
+// range), while one landing past "to" must have none published, even though
+// every op in a transaction shares the outer document's timestamp.
+func TestUnmarshalEntryStopPredicateAppliesPerEntry(t *testing.T) {
+	cases := []struct {
+		name          string
+		to            bson.MongoTimestamp
+		docTimestamp  bson.MongoTimestamp
+		wantPublished int
+	}{
+		{
+			name:          "transaction exactly at the replay bound publishes all its ops",
+			to:            20,
+			docTimestamp:  20,
+			wantPublished: 2,
+		},
+		{
+			name:          "transaction past the replay bound publishes nothing",
+			to:            20,
+			docTimestamp:  21,
+			wantPublished: 0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tailer := &Tailer{}
+			raw := rawTransactionDoc(t, c.docTimestamp, 1, 2)
+
+			pastTo := func(ts bson.MongoTimestamp) bool { return ts > c.to }
+
+			out := make(chan *redispub.Publication, 2)
+			ts := tailer.unmarshalEntry(raw, out, pastTo)
+			close(out)
+
+			if ts == nil || *ts != c.docTimestamp {
+				t.Fatalf("expected returned timestamp %d, got %v", c.docTimestamp, ts)
+			}
+
+			var published int
+			for range out {
+				published++
+			}
+
+			if published != c.wantPublished {
+				t.Fatalf("expected %d published entries, got %d", c.wantPublished, published)
+			}
+		})
+	}
+}
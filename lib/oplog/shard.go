@@ -0,0 +1,110 @@
+package oplog
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// shardSubChannels is the number of ordered sub-channels that entries are
+// hashed across before being drained into the shared out channel. Entries
+// for the same (database, collection, docID) always hash to the same
+// sub-channel, so they're never reordered relative to each other, even
+// though they may come from different shards; entries for different docs
+// may be interleaved.
+const shardSubChannels = 64
+
+// shardedPublication carries a Publication through a sub-channel alongside
+// the bookkeeping TailShards needs to persist its shard's resume timestamp
+// once the publication is actually drained out to the shared out channel,
+// rather than when it's merely handed to the sub-channel.
+type shardedPublication struct {
+	pub       *redispub.Publication
+	shardName string
+	timestamp bson.MongoTimestamp
+}
+
+// TailShards tails a set of per-shard Tailers in parallel -- one goroutine
+// per shard -- and merges their output into a single out channel, preserving
+// per-(database, collection, docID) ordering across shards. It does not
+// discover shards itself: shards must already hold one Tailer per shard,
+// each with its ShardName set.
+//
+// It doesn't return unless ctx is canceled, in which case it waits for all
+// shards to wrap up before returning.
+func TailShards(ctx context.Context, shards []*Tailer, out chan<- *redispub.Publication) {
+	subChannels := make([]chan shardedPublication, shardSubChannels)
+	for i := range subChannels {
+		subChannels[i] = make(chan shardedPublication)
+	}
+
+	// All shards write their resume timestamps through the same Redis
+	// bookkeeping client/prefix (it's the sharded counterpart of the single
+	// LastProcessedTimestamp key, just partitioned by shard name within one
+	// hash), so any shard's Tailer can be used to persist any other
+	// shard's timestamp.
+	redisClient := shards[0].RedisClient
+	redisPrefix := shards[0].RedisPrefix
+
+	var drainWg sync.WaitGroup
+	drainWg.Add(len(subChannels))
+	for _, sub := range subChannels {
+		go func(sub chan shardedPublication) {
+			defer drainWg.Done()
+			for item := range sub {
+				out <- item.pub
+
+				if setErr := redispub.SetLastProcessedTimestampForShard(ctx, redisClient, redisPrefix, item.shardName, item.timestamp); setErr != nil {
+					log.Log.Errorw("Error persisting shard resume timestamp",
+						"shard", item.shardName,
+						"error", setErr)
+				}
+			}
+		}(sub)
+	}
+
+	var shardWg sync.WaitGroup
+	shardWg.Add(len(shards))
+	for _, shard := range shards {
+		shard.route = func(entry *oplogEntry) chan<- shardedPublication {
+			return subChannels[shardKeyHash(entry)]
+		}
+
+		go func(shard *Tailer) {
+			defer shardWg.Done()
+			log.Log.Infow("Starting shard tailing", "shard", shard.ShardName)
+			shard.Tail(ctx, nil)
+			log.Log.Infow("Shard tailing ended", "shard", shard.ShardName)
+		}(shard)
+	}
+
+	shardWg.Wait()
+
+	for _, sub := range subChannels {
+		close(sub)
+	}
+	drainWg.Wait()
+}
+
+// shardKeyHash hashes an entry's (database, collection, docID) to a bounded
+// sub-channel index, so that all entries for the same document always land
+// on the same sub-channel regardless of which shard they came from.
+func shardKeyHash(entry *oplogEntry) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(entry.Database))
+	_, _ = h.Write([]byte("."))
+	_, _ = h.Write([]byte(entry.Collection))
+	_, _ = h.Write([]byte("."))
+	// DocID can be any BSON scalar or document; %v gives a stable-enough
+	// representation for partitioning purposes -- we don't need it to be
+	// canonical, just consistent for a given doc.
+	_, _ = fmt.Fprintf(h, "%v", entry.DocID)
+
+	return int(h.Sum32() % shardSubChannels)
+}
@@ -0,0 +1,49 @@
+package oplog
+
+import "testing"
+
+// TestShardKeyHashSameDocAlwaysHashesToSameSubChannel covers the ordering
+// guarantee TailShards relies on: entries for the same (database,
+// collection, docID) must land on the same sub-channel regardless of which
+// shard produced them, or concurrent shards could reorder them relative to
+// each other.
+func TestShardKeyHashSameDocAlwaysHashesToSameSubChannel(t *testing.T) {
+	entryA := &oplogEntry{Database: "mydb", Collection: "mycoll", DocID: "doc-1"}
+	entryB := &oplogEntry{Database: "mydb", Collection: "mycoll", DocID: "doc-1"}
+
+	if shardKeyHash(entryA) != shardKeyHash(entryB) {
+		t.Fatalf("expected identical (database, collection, docID) to hash to the same sub-channel")
+	}
+}
+
+func TestShardKeyHashIgnoresFieldsOutsideTheDocKey(t *testing.T) {
+	base := &oplogEntry{Database: "mydb", Collection: "mycoll", DocID: "doc-1"}
+	differentOp := &oplogEntry{Database: "mydb", Collection: "mycoll", DocID: "doc-1", Operation: operationUpdate}
+
+	if shardKeyHash(base) != shardKeyHash(differentOp) {
+		t.Fatalf("expected hash to depend only on (database, collection, docID), not other entry fields")
+	}
+}
+
+func TestShardKeyHashBoundedRange(t *testing.T) {
+	for i := 0; i < shardSubChannels*4; i++ {
+		entry := &oplogEntry{Database: "mydb", Collection: "mycoll", DocID: i}
+
+		idx := shardKeyHash(entry)
+		if idx < 0 || idx >= shardSubChannels {
+			t.Fatalf("shardKeyHash(%d) = %d, want in [0, %d)", i, idx, shardSubChannels)
+		}
+	}
+}
+
+func TestShardKeyHashSpreadsAcrossSubChannels(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < shardSubChannels*4; i++ {
+		entry := &oplogEntry{Database: "mydb", Collection: "mycoll", DocID: i}
+		seen[shardKeyHash(entry)] = true
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("expected distinct docIDs to spread across more than one sub-channel, got %d distinct indices", len(seen))
+	}
+}
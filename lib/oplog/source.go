@@ -0,0 +1,56 @@
+package oplog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Source identifies which backend a Tailer should use to read changes from
+// MongoDB.
+type Source string
+
+// Supported values for Source, and the default.
+const (
+	// SourceOplog tails local.oplog.rs directly, via Tailer. This is the
+	// original, default behavior; it requires direct access to the oplog
+	// collection and manual timestamp bookkeeping, but works against older
+	// MongoDB versions and doesn't require the official driver.
+	SourceOplog Source = "oplog"
+
+	// SourceChangeStream opens a cluster-wide change stream, via
+	// ChangeStreamTailer. This is the preferred option for sharded
+	// clusters, where each shard has its own oplog and there's no single
+	// timestamp that represents "caught up" across the whole cluster.
+	SourceChangeStream Source = "changestream"
+
+	// DefaultSource is used when no source is configured, to preserve the
+	// behavior of existing deployments.
+	DefaultSource = SourceOplog
+)
+
+// tailer is implemented by both Tailer and ChangeStreamTailer. It lets
+// callers (and tests) work against either source without caring which one
+// is in use.
+type tailer interface {
+	Tail(ctx context.Context, out chan<- *redispub.Publication)
+}
+
+var _ tailer = &Tailer{}
+var _ tailer = &ChangeStreamTailer{}
+
+// ParseSource validates a source name from config, returning DefaultSource
+// if the empty string is passed.
+func ParseSource(name string) (Source, error) {
+	switch Source(name) {
+	case "":
+		return DefaultSource, nil
+	case SourceOplog:
+		return SourceOplog, nil
+	case SourceChangeStream:
+		return SourceChangeStream, nil
+	default:
+		return "", fmt.Errorf("unrecognized oplog source %q (expected %q or %q)", name, SourceOplog, SourceChangeStream)
+	}
+}
@@ -1,9 +1,14 @@
 // Package oplog tails a MongoDB oplog, process each message, and generates
 // the message that should be sent to Redis. It writes these to an output
 // channel that should be read by package redispub and sent to the Redis server.
+//
+// Two sources are supported (see Source): Tailer reads local.oplog.rs
+// directly, and ChangeStreamTailer opens a cluster-wide change stream. Both
+// satisfy the tailer interface in source.go.
 package oplog
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -12,7 +17,7 @@ import (
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -24,6 +29,26 @@ type Tailer struct {
 	RedisClient redis.UniversalClient
 	RedisPrefix string
 	MaxCatchUp  time.Duration
+
+	// Transformers is a chain applied to each entry parsed from the oplog,
+	// before it's processed and published. See transform.go.
+	Transformers []Transformer
+
+	// ShardName, if non-empty, identifies which shard this Tailer reads
+	// from. It's used as the key for this Tailer's resume timestamp in
+	// Redis (so restarting resumes each shard independently, see
+	// redispub.LastProcessedTimestampForShard) and as the "shard" label on
+	// metricOplogEntriesReceived. Set by the caller on each per-shard Tailer
+	// it passes to TailShards; left empty for a Tailer used standalone
+	// against a single oplog.
+	ShardName string
+
+	// route, if set, is consulted instead of sending published entries
+	// directly to the out channel passed to Tail/tailOnce. TailShards sets
+	// this to fan entries out to ordered per-doc-key sub-channels instead
+	// of a single shared channel, so that concurrently-tailed shards can't
+	// reorder entries for the same document relative to each other.
+	route func(entry *oplogEntry) chan<- shardedPublication
 }
 
 // Raw oplog entry from Mongo
@@ -49,27 +74,18 @@ var (
 		Subsystem: "oplog",
 		Name:      "entries_by_size",
 		Help:      "Oplog entries by size.",
-	}, []string{"database", "status"})
+	}, []string{"database", "status", "shard"})
 )
 
-// Tail begins tailing the oplog. It doesn't return unless it receives a message
-// on the stop channel, in which case it wraps up its work and then returns.
-func (tailer *Tailer) Tail(out chan<- *redispub.Publication, stop <-chan bool) {
-	childStopC := make(chan bool)
-	wasStopped := false
-
-	go func() {
-		<-stop
-		wasStopped = true
-		childStopC <- true
-	}()
-
+// Tail begins tailing the oplog. It doesn't return unless ctx is canceled,
+// in which case it wraps up its work and then returns.
+func (tailer *Tailer) Tail(ctx context.Context, out chan<- *redispub.Publication) {
 	for {
 		log.Log.Info("Starting oplog tailing")
-		tailer.tailOnce(out, childStopC)
+		tailer.tailOnce(ctx, out)
 		log.Log.Info("Oplog tailing ended")
 
-		if wasStopped {
+		if ctx.Err() != nil {
 			return
 		}
 
@@ -78,11 +94,11 @@ func (tailer *Tailer) Tail(out chan<- *redispub.Publication, stop <-chan bool) {
 	}
 }
 
-func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan bool) {
+func (tailer *Tailer) tailOnce(ctx context.Context, out chan<- *redispub.Publication) {
 	session := tailer.MongoClient.Copy()
 	oplogCollection := session.DB("local").C("oplog.rs")
 
-	startTime := tailer.getStartTime(func() (bson.MongoTimestamp, error) {
+	startTime := tailer.getStartTime(ctx, func() (bson.MongoTimestamp, error) {
 		// Get the timestamp of the last entry in the oplog (as a position to
 		// start from if we don't have a last-written timestamp from Redis)
 		var entry rawOplogEntry
@@ -95,14 +111,22 @@ func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan boo
 		return entry.Timestamp, mongoErr
 	})
 
+	tailer.tailOnceFrom(ctx, oplogCollection, out, startTime, nil)
+}
+
+// tailOnceFrom is the shared implementation behind tailOnce and Replay. It
+// tails the oplog starting strictly after startTime, until ctx is canceled,
+// the oplog iterator returns an error, or stopPredicate (if non-nil) returns
+// true for the timestamp of the most recently read oplog document.
+func (tailer *Tailer) tailOnceFrom(ctx context.Context, oplogCollection *mgo.Collection, out chan<- *redispub.Publication, startTime bson.MongoTimestamp, stopPredicate func(bson.MongoTimestamp) bool) {
 	query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": startTime}})
 	iter := query.LogReplay().Sort("$natural").Tail(requeryDuration)
 
 	var lastTimestamp bson.MongoTimestamp
 	for {
 		select {
-		case <-stop:
-			log.Log.Infof("Received stop; aborting oplog tailing")
+		case <-ctx.Done():
+			log.Log.Infof("Context canceled; aborting oplog tailing")
 			return
 		default:
 		}
@@ -110,14 +134,20 @@ func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan boo
 		var rawData bson.Raw
 
 		for iter.Next(&rawData) {
-			ts, pubs := tailer.unmarshalEntry(rawData)
+			ts := tailer.unmarshalEntry(rawData, out, stopPredicate)
 
 			if ts != nil {
 				lastTimestamp = *ts
 			}
 
-			for _, pub := range pubs {
-				out <- pub
+			if ts != nil && stopPredicate != nil && stopPredicate(*ts) {
+				log.Log.Infof("Reached replay end timestamp; stopping")
+				closeErr := iter.Close()
+				if closeErr != nil {
+					log.Log.Errorw("Error from closing oplog iterator",
+						"error", closeErr)
+				}
+				return
 			}
 		}
 
@@ -147,11 +177,18 @@ func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan boo
 	}
 }
 
-// unmarshalEntry unmarshals a single entry from the oplog.
+// unmarshalEntry unmarshals a single entry from the oplog and delivers any
+// resulting Publications to out (or, if tailer.route is set, to whichever
+// channel it returns for that entry). If stopPredicate is non-nil, entries
+// it matches (used by Replay, to mark entries past the replay bound) are
+// not delivered -- the raw oplog document can contain a transaction with
+// multiple logical entries, some of which may cross the replay bound, so
+// this is checked per entry rather than relying on the caller to stop
+// after the whole document.
 //
 // The timestamp of the entry is returned so that tailOnce knows the timestamp of the last entry it read, even if it
 // ignored it or failed at some later step.
-func (tailer *Tailer) unmarshalEntry(rawData bson.Raw) (timestamp *bson.MongoTimestamp, pubs []*redispub.Publication) {
+func (tailer *Tailer) unmarshalEntry(rawData bson.Raw, out chan<- *redispub.Publication, stopPredicate func(bson.MongoTimestamp) bool) (timestamp *bson.MongoTimestamp) {
 	var result rawOplogEntry
 
 	err := rawData.Unmarshal(&result)
@@ -169,29 +206,66 @@ func (tailer *Tailer) unmarshalEntry(rawData bson.Raw) (timestamp *bson.MongoTim
 	status := "ignored"
 	database := "(no database)"
 	defer func() {
-		metricOplogEntriesReceived.WithLabelValues(database, status).Observe(float64(len(rawData.Data)))
+		metricOplogEntriesReceived.WithLabelValues(database, status, tailer.ShardName).Observe(float64(len(rawData.Data)))
 	}()
 
 	if len(entries) == 0 {
 		return
 	}
 
+	originalCount := len(entries)
+	entries, transformErr := runTransformers(tailer.Transformers, entries)
+	if transformErr != nil {
+		status = "error"
+		log.Log.Errorw("Error transforming oplog entry", "error", transformErr)
+		return
+	}
+
+	if len(entries) == 0 {
+		status = "filtered"
+		return
+	}
+
+	transformed := len(entries) != originalCount
+
 	database = entries[0].Database
 
 	for _, entry := range entries {
+		if stopPredicate != nil && stopPredicate(entry.Timestamp) {
+			continue
+		}
+
 		pub, err := processOplogEntry(&entry)
 		if err != nil {
 			status = "error"
-			pub = nil
 
 			log.Log.Errorw("Error processing oplog entry",
 				"op", entry,
 				"error", err,
 				"database", entry.Database,
 				"collection", entry.Collection)
+			continue
+		}
+
+		if transformed {
+			status = "transformed"
 		} else {
 			status = "processed"
-			pubs = append(pubs, pub)
+		}
+
+		if tailer.route != nil {
+			// Routed (sharded) entries carry their shard's resume timestamp
+			// along with them, so it's only persisted once the publication
+			// actually drains out of its ordering sub-channel into out --
+			// see TailShards -- rather than here, where it's merely been
+			// handed to that internal buffer.
+			tailer.route(&entry) <- shardedPublication{
+				pub:       pub,
+				shardName: tailer.ShardName,
+				timestamp: entry.Timestamp,
+			}
+		} else {
+			out <- pub
 		}
 	}
 
@@ -203,8 +277,16 @@ func (tailer *Tailer) unmarshalEntry(rawData bson.Raw) (timestamp *bson.MongoTim
 // We take the function to get the timestamp of the last oplog entry (as a
 // fallback if we don't have a latest timestamp from Redis) as an arg instead
 // of using tailer.mongoClient directly so we can unit test this function
-func (tailer *Tailer) getStartTime(getTimestampOfLastOplogEntry func() (bson.MongoTimestamp, error)) bson.MongoTimestamp {
-	ts, tsTime, redisErr := redispub.LastProcessedTimestamp(tailer.RedisClient, tailer.RedisPrefix)
+func (tailer *Tailer) getStartTime(ctx context.Context, getTimestampOfLastOplogEntry func() (bson.MongoTimestamp, error)) bson.MongoTimestamp {
+	var ts bson.MongoTimestamp
+	var tsTime time.Time
+	var redisErr error
+
+	if tailer.ShardName != "" {
+		ts, tsTime, redisErr = redispub.LastProcessedTimestampForShard(ctx, tailer.RedisClient, tailer.RedisPrefix, tailer.ShardName)
+	} else {
+		ts, tsTime, redisErr = redispub.LastProcessedTimestamp(ctx, tailer.RedisClient, tailer.RedisPrefix)
+	}
 
 	if redisErr == nil {
 		// we have a last write time, check that it's not too far in the
@@ -0,0 +1,45 @@
+package oplog
+
+// Transformer is applied to each entry parsed from the oplog before it's
+// published, letting operators filter out noisy collections or reshape
+// entries without patching this package. A Transformer may drop an entry
+// (by returning no entries), pass it through unchanged, mutate it, or fan
+// it out into multiple entries.
+//
+// See NamespaceFilter for a declarative include/exclude implementation, and
+// ScriptTransformer for a scripted one.
+type Transformer interface {
+	Transform(entry *oplogEntry) ([]*oplogEntry, error)
+}
+
+// runTransformers passes entries through transformers in order, threading
+// the output of one transformer into the next. An error from any
+// transformer aborts the whole raw oplog entry (or change event), so a
+// buggy script can't silently drop half of a multi-document transaction.
+// Shared by Tailer and ChangeStreamTailer so both sources apply the same
+// NamespaceFilter/ScriptTransformer chain.
+func runTransformers(transformers []Transformer, entries []oplogEntry) ([]oplogEntry, error) {
+	if len(transformers) == 0 {
+		return entries, nil
+	}
+
+	current := entries
+	for _, transformer := range transformers {
+		var next []oplogEntry
+
+		for i := range current {
+			results, err := transformer.Transform(&current[i])
+			if err != nil {
+				return nil, err
+			}
+
+			for _, result := range results {
+				next = append(next, *result)
+			}
+		}
+
+		current = next
+	}
+
+	return current, nil
+}
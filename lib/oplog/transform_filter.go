@@ -0,0 +1,57 @@
+package oplog
+
+// NamespaceFilter is a Transformer that drops entries for collections
+// operators don't want republished, without needing to patch this package.
+// It's the declarative counterpart to ScriptTransformer: configure it with
+// include/exclude rules, typically loaded from a YAML or JSON config file,
+// instead of writing a script.
+//
+// Rules are evaluated in order; the first matching rule decides the
+// entry's fate. If no rule matches, the entry is passed through unless
+// DefaultExclude is set.
+type NamespaceFilter struct {
+	Rules          []NamespaceRule `yaml:"rules" json:"rules"`
+	DefaultExclude bool            `yaml:"defaultExclude" json:"defaultExclude"`
+}
+
+// NamespaceRule is a single include/exclude rule in a NamespaceFilter.
+// Empty fields match any value; Operation matches one of
+// operationInsert/operationUpdate/operationRemove/operationCommand.
+type NamespaceRule struct {
+	Database   string `yaml:"database" json:"database"`
+	Collection string `yaml:"collection" json:"collection"`
+	Operation  string `yaml:"operation" json:"operation"`
+	Exclude    bool   `yaml:"exclude" json:"exclude"`
+}
+
+// Transform implements Transformer.
+func (f *NamespaceFilter) Transform(entry *oplogEntry) ([]*oplogEntry, error) {
+	for _, rule := range f.Rules {
+		if !rule.matches(entry) {
+			continue
+		}
+
+		if rule.Exclude {
+			return nil, nil
+		}
+		return []*oplogEntry{entry}, nil
+	}
+
+	if f.DefaultExclude {
+		return nil, nil
+	}
+	return []*oplogEntry{entry}, nil
+}
+
+func (rule NamespaceRule) matches(entry *oplogEntry) bool {
+	if rule.Database != "" && rule.Database != entry.Database {
+		return false
+	}
+	if rule.Collection != "" && rule.Collection != entry.Collection {
+		return false
+	}
+	if rule.Operation != "" && rule.Operation != entry.Operation {
+		return false
+	}
+	return true
+}
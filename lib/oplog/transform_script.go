@@ -0,0 +1,91 @@
+package oplog
+
+import (
+	"fmt"
+
+	"github.com/dop251/goja"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ScriptTransformer runs a user-supplied JavaScript function against each
+// oplog entry, letting operators mutate, drop, or fan out entries without
+// building a new binary. The script must define a global function
+// `transform(entry)` taking an object with `op`, `ns`, `data`, `docID`, and
+// `ts` fields; it should return an array of entries in the same shape (or a
+// falsy value to drop the input entry).
+//
+// A ScriptTransformer wraps a single goja.Runtime and is not safe for
+// concurrent use, same as Tailer itself -- use one ScriptTransformer per
+// Tailer.
+type ScriptTransformer struct {
+	runtime   *goja.Runtime
+	transform goja.Callable
+}
+
+// NewScriptTransformer compiles script and returns a ScriptTransformer that
+// will run its "transform" function against each entry.
+func NewScriptTransformer(script string) (*ScriptTransformer, error) {
+	runtime := goja.New()
+	runtime.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	if _, err := runtime.RunString(script); err != nil {
+		return nil, fmt.Errorf("compiling transform script: %w", err)
+	}
+
+	fn, ok := goja.AssertFunction(runtime.Get("transform"))
+	if !ok {
+		return nil, fmt.Errorf("transform script must define a global 'transform' function")
+	}
+
+	return &ScriptTransformer{runtime: runtime, transform: fn}, nil
+}
+
+// scriptEntry is the shape passed into and expected back from the script.
+type scriptEntry struct {
+	Op    string                 `json:"op"`
+	NS    string                 `json:"ns"`
+	Data  map[string]interface{} `json:"data"`
+	DocID interface{}            `json:"docID"`
+	TS    int64                  `json:"ts"`
+}
+
+// Transform implements Transformer.
+func (t *ScriptTransformer) Transform(entry *oplogEntry) ([]*oplogEntry, error) {
+	input := scriptEntry{
+		Op:    entry.Operation,
+		NS:    entry.Namespace,
+		Data:  entry.Data,
+		DocID: entry.DocID,
+		TS:    int64(entry.Timestamp),
+	}
+
+	result, err := t.transform(goja.Undefined(), t.runtime.ToValue(input))
+	if err != nil {
+		return nil, fmt.Errorf("running transform script: %w", err)
+	}
+
+	if goja.IsUndefined(result) || goja.IsNull(result) || !result.ToBoolean() {
+		return nil, nil
+	}
+
+	var outputs []scriptEntry
+	if err := t.runtime.ExportTo(result, &outputs); err != nil {
+		return nil, fmt.Errorf("transform script returned an unexpected value: %w", err)
+	}
+
+	entries := make([]*oplogEntry, 0, len(outputs))
+	for _, out := range outputs {
+		transformed := *entry
+		transformed.Operation = out.Op
+		transformed.Namespace = out.NS
+		transformed.Data = out.Data
+		transformed.DocID = out.DocID
+		transformed.Timestamp = bson.MongoTimestamp(out.TS)
+		transformed.Database, transformed.Collection = parseNamespace(transformed.Namespace)
+
+		entries = append(entries, &transformed)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,35 @@
+package redispub
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/extra/redisotel/v8"
+	"github.com/go-redis/redis/v8"
+)
+
+// NewClientFromURI builds a redis.UniversalClient from a single connection
+// string, as an alternative to constructing one from an address list. This
+// matches the redis://, rediss:// (TLS) URI forms that redis.ParseURL
+// supports.
+//
+// The returned client has OpenTelemetry tracing hooks installed, so every
+// command it runs (including the LastProcessedTimestamp reads and publishes
+// in this package) shows up as a span.
+func NewClientFromURI(uri string) (redis.UniversalClient, error) {
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --redis-uri %q: %w", uri, err)
+	}
+
+	client := redis.NewClient(opts)
+	client.AddHook(redisotel.NewTracingHook())
+
+	return client, nil
+}
+
+// InstrumentClient adds OpenTelemetry tracing hooks to an already-constructed
+// client (e.g. one built from the existing address-list config), so
+// address-list and URI-based deployments get the same tracing.
+func InstrumentClient(client redis.UniversalClient) {
+	client.AddHook(redisotel.NewTracingHook())
+}
@@ -0,0 +1,249 @@
+package redispub
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Config describes how to connect to the Redis endpoint(s) that
+// oplogtoredis reads/writes LastProcessedTimestamp from and publishes to.
+type Config struct {
+	// URIs is one or more connection strings. All URIs must share the same
+	// scheme:
+	//
+	//   redis://host:port/db        a single standalone node
+	//   rediss://host:port/db       a single standalone node, over TLS
+	//   redis+sentinel://host:port  one Sentinel address (repeat for more); requires MasterName
+	//   redis+cluster://host:port   one cluster seed node (repeat for more)
+	URIs []string
+
+	// MasterName is the name of the monitored master, as configured in
+	// Sentinel. Required when URIs use the redis+sentinel:// scheme.
+	MasterName string
+}
+
+var (
+	metricRedisNodeUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "otr",
+		Subsystem: "redis",
+		Name:      "node_up",
+		Help:      "Whether the most recent PING to a Redis node succeeded (1) or not (0).",
+	}, []string{"addr"})
+
+	// clientRegistry dedupes clients by Config, so that multiple Tailer (or
+	// ChangeStreamTailer) instances configured against the same endpoint
+	// set share a single connection pool instead of each opening their own.
+	clientRegistry   = map[string]redis.UniversalClient{}
+	clientRegistryMu sync.Mutex
+)
+
+// NewClient returns a redis.UniversalClient for cfg, constructing a
+// *redis.Client, *redis.ClusterClient, or *redis.FailoverClient depending on
+// cfg's scheme. If a client for an identical Config has already been built,
+// the existing client is returned instead of opening a new connection pool.
+//
+// The returned client has OpenTelemetry tracing hooks installed (see
+// InstrumentClient), so every command -- including the
+// LastProcessedTimestamp reads and publishes elsewhere in this package --
+// shows up as a span.
+func NewClient(cfg Config) (redis.UniversalClient, error) {
+	key := registryKey(cfg)
+
+	clientRegistryMu.Lock()
+	if existing, ok := clientRegistry[key]; ok {
+		clientRegistryMu.Unlock()
+		return existing, nil
+	}
+
+	client, err := buildClient(cfg)
+	if err != nil {
+		clientRegistryMu.Unlock()
+		return nil, err
+	}
+
+	InstrumentClient(client)
+	clientRegistry[key] = client
+	clientRegistryMu.Unlock()
+
+	// The slot preload is a blocking network round trip; it runs after
+	// releasing clientRegistryMu so that building a client for one cluster
+	// doesn't stall concurrent NewClient calls for unrelated configs.
+	if clusterClient, ok := client.(*redis.ClusterClient); ok {
+		if err := preloadClusterSlots(clusterClient); err != nil {
+			log.Log.Errorw("Error preloading Redis cluster slot map", "error", err)
+		}
+	}
+
+	go runNodeHealthLoop(client)
+
+	return client, nil
+}
+
+// nodeHealthInterval is how often runNodeHealthLoop re-checks node health.
+const nodeHealthInterval = 30 * time.Second
+
+// runNodeHealthLoop calls refreshNodeHealth immediately and then on every
+// tick of nodeHealthInterval, for as long as the process runs, so
+// metricRedisNodeUp reflects a node going down or recovering rather than
+// just its state at startup.
+func runNodeHealthLoop(client redis.UniversalClient) {
+	refreshNodeHealth(client)
+
+	ticker := time.NewTicker(nodeHealthInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		refreshNodeHealth(client)
+	}
+}
+
+// registryKey builds a stable dedup key from a Config, independent of URI
+// ordering.
+func registryKey(cfg Config) string {
+	uris := append([]string(nil), cfg.URIs...)
+	sort.Strings(uris)
+	return cfg.MasterName + "|" + strings.Join(uris, ",")
+}
+
+func buildClient(cfg Config) (redis.UniversalClient, error) {
+	scheme, err := schemeOf(cfg.URIs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "redis", "rediss":
+		if len(cfg.URIs) != 1 {
+			return nil, fmt.Errorf("%q URIs only support a single endpoint; use redis+cluster:// for multiple nodes", scheme)
+		}
+
+		opts, err := redis.ParseURL(cfg.URIs[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing redis URI: %w", err)
+		}
+		return redis.NewClient(opts), nil
+
+	case "redis+sentinel":
+		if cfg.MasterName == "" {
+			return nil, fmt.Errorf("redis+sentinel:// requires Config.MasterName")
+		}
+
+		addrs, opts, err := addrsFromURIs(cfg.URIs, scheme)
+		if err != nil {
+			return nil, err
+		}
+
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.MasterName,
+			SentinelAddrs: addrs,
+			Password:      opts.Password,
+			DB:            opts.DB,
+		}), nil
+
+	case "redis+cluster":
+		addrs, opts, err := addrsFromURIs(cfg.URIs, scheme)
+		if err != nil {
+			return nil, err
+		}
+
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    addrs,
+			Password: opts.Password,
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported redis URI scheme %q", scheme)
+	}
+}
+
+// schemeOf returns the scheme shared by all of uris, erroring if uris is
+// empty or the schemes disagree.
+func schemeOf(uris []string) (string, error) {
+	if len(uris) == 0 {
+		return "", fmt.Errorf("at least one Redis URI is required")
+	}
+
+	first, err := url.Parse(uris[0])
+	if err != nil {
+		return "", fmt.Errorf("parsing redis URI %q: %w", uris[0], err)
+	}
+
+	for _, uri := range uris[1:] {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return "", fmt.Errorf("parsing redis URI %q: %w", uri, err)
+		}
+		if parsed.Scheme != first.Scheme {
+			return "", fmt.Errorf("all redis URIs must use the same scheme (got %q and %q)", first.Scheme, parsed.Scheme)
+		}
+	}
+
+	return first.Scheme, nil
+}
+
+// addrsFromURIs strips the +sentinel/+cluster scheme suffix from each URI
+// (so it can be parsed by redis.ParseURL as a standard redis:// URI) and
+// returns the host:port of each, along with the options parsed from the
+// first URI (for the password/DB that apply to the whole set).
+func addrsFromURIs(uris []string, scheme string) (addrs []string, opts *redis.Options, err error) {
+	standardScheme := strings.TrimSuffix(strings.TrimSuffix(scheme, "+sentinel"), "+cluster")
+
+	for i, uri := range uris {
+		rewritten := standardScheme + strings.TrimPrefix(uri, scheme)
+
+		parsed, parseErr := redis.ParseURL(rewritten)
+		if parseErr != nil {
+			return nil, nil, fmt.Errorf("parsing redis URI %q: %w", uri, parseErr)
+		}
+
+		addrs = append(addrs, parsed.Addr)
+		if i == 0 {
+			opts = parsed
+		}
+	}
+
+	return addrs, opts, nil
+}
+
+// preloadClusterSlots eagerly fetches the cluster's slot map, instead of
+// waiting for the first command to trigger a MOVED redirect and lazy load.
+func preloadClusterSlots(client *redis.ClusterClient) error {
+	return client.ReloadState(context.Background())
+}
+
+// refreshNodeHealth pings every node reachable through client and records
+// the result in metricRedisNodeUp, so per-node availability shows up in
+// Prometheus alongside metricOplogEntriesReceived.
+func refreshNodeHealth(client redis.UniversalClient) {
+	ctx := context.Background()
+
+	clusterClient, ok := client.(*redis.ClusterClient)
+	if !ok {
+		up := float64(0)
+		if client.Ping(ctx).Err() == nil {
+			up = 1
+		}
+		metricRedisNodeUp.WithLabelValues("default").Set(up)
+		return
+	}
+
+	_ = clusterClient.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+		up := float64(0)
+		if shard.Ping(ctx).Err() == nil {
+			up = 1
+		}
+		metricRedisNodeUp.WithLabelValues(shard.Options().Addr).Set(up)
+		return nil
+	})
+}
@@ -0,0 +1,57 @@
+package redispub
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis/v8"
+)
+
+// lastProcessedTimestampsKeySuffix is appended to the Redis prefix to build
+// the key of the hash that holds each shard's last-processed timestamp,
+// keyed by shard name. This is the sharded counterpart to whatever single
+// key LastProcessedTimestamp uses -- a sharded Tailer has no single
+// "caught up" position, so each shard's timestamp is tracked independently.
+const lastProcessedTimestampsKeySuffix = ".lastProcessedTimestamps"
+
+// LastProcessedTimestampForShard returns the last-processed timestamp for a
+// single shard, along with the time it was written, so a restarted
+// shard's Tailer can resume only that shard from where it left off.
+func LastProcessedTimestampForShard(ctx context.Context, client redis.UniversalClient, prefix, shard string) (bson.MongoTimestamp, time.Time, error) {
+	result, err := client.HGet(ctx, prefix+lastProcessedTimestampsKeySuffix, shard).Bytes()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var wrapper struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+		Time      time.Time           `bson:"time"`
+	}
+
+	if err := bson.Unmarshal(result, &wrapper); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return wrapper.Timestamp, wrapper.Time, nil
+}
+
+// SetLastProcessedTimestampForShard persists a single shard's last-processed
+// timestamp, so tailing that shard can resume from it after a restart
+// without affecting any other shard's resume position.
+func SetLastProcessedTimestampForShard(ctx context.Context, client redis.UniversalClient, prefix, shard string, ts bson.MongoTimestamp) error {
+	wrapper := struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+		Time      time.Time           `bson:"time"`
+	}{
+		Timestamp: ts,
+		Time:      time.Now(),
+	}
+
+	data, err := bson.Marshal(wrapper)
+	if err != nil {
+		return err
+	}
+
+	return client.HSet(ctx, prefix+lastProcessedTimestampsKeySuffix, shard, data).Err()
+}
@@ -0,0 +1,63 @@
+package redispub
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis/v8"
+)
+
+// lastProcessedTokenKeySuffix is appended to the Redis prefix to build the key
+// under which we store the opaque resume token for sources (such as change
+// streams) that don't resume from a bson.MongoTimestamp.
+const lastProcessedTokenKeySuffix = ".lastProcessedToken"
+
+// LastProcessedToken returns the last change-stream resume token we
+// persisted, along with the time it was written, so callers can decide
+// whether it's too stale to resume from (the same MaxCatchUp check that
+// LastProcessedTimestamp supports).
+//
+// This is the change-stream analog of LastProcessedTimestamp: that function
+// stores a bson.MongoTimestamp, which only makes sense for a single oplog.
+// A resume token is an opaque BSON document scoped to the cluster (or shard)
+// the change stream was opened against, so we store it as raw bytes instead
+// -- callers (e.g. ChangeStreamTailer, which speaks the mongo-driver's own
+// bson.Raw, a distinct []byte-based type from globalsign/mgo/bson.Raw) are
+// expected to wrap/unwrap those bytes into whichever Raw type they need.
+func LastProcessedToken(ctx context.Context, client redis.UniversalClient, prefix string) ([]byte, time.Time, error) {
+	result, err := client.Get(ctx, prefix+lastProcessedTokenKeySuffix).Bytes()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var wrapper struct {
+		Token []byte    `bson:"token"`
+		Time  time.Time `bson:"time"`
+	}
+
+	if err := bson.Unmarshal(result, &wrapper); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return wrapper.Token, wrapper.Time, nil
+}
+
+// SetLastProcessedToken persists a change-stream resume token so tailing can
+// resume from it after a restart.
+func SetLastProcessedToken(ctx context.Context, client redis.UniversalClient, prefix string, token []byte) error {
+	wrapper := struct {
+		Token []byte    `bson:"token"`
+		Time  time.Time `bson:"time"`
+	}{
+		Token: token,
+		Time:  time.Now(),
+	}
+
+	data, err := bson.Marshal(wrapper)
+	if err != nil {
+		return err
+	}
+
+	return client.Set(ctx, prefix+lastProcessedTokenKeySuffix, data, 0).Err()
+}